@@ -27,7 +27,7 @@ func main() {
 	config := logging.DefaultApacheLogConfig()
 
 	// You can customize the config if needed
-	// config.LogPath = "/custom/path/access.log"
+	// config.Outputs = []string{"stdout", "file:/custom/path/access.log", "syslog://logs.internal:514"}
 	// config.MaxSize = 50
 
 	// Set the log format - use Combined instead of Common if you want Referer and User-Agent
@@ -37,7 +37,7 @@ func main() {
 	if err != nil {
 		serverLogger.Fatal("Failed to create access logger",
 			zap.Error(err),
-			zap.String("logPath", config.LogPath))
+			zap.Strings("outputs", config.Outputs))
 	}
 	defer accessLogger.Sync()
 
@@ -51,8 +51,10 @@ func main() {
 		for {
 			select {
 			case <-ticker.C:
-				if err := logging.SecureRotatedLogs(config.LogPath); err != nil {
-					serverLogger.Error("Failed to secure log files", zap.Error(err))
+				for _, path := range config.FilePaths() {
+					if err := logging.SecureRotatedLogs(path); err != nil {
+						serverLogger.Error("Failed to secure log files", zap.Error(err), zap.String("logPath", path))
+					}
 				}
 			case <-ctx.Done():
 				return
@@ -70,7 +72,13 @@ func main() {
 	})
 
 	// Add the Apache Log Format middleware with the configured format
-	handler := logging.ApacheLogMiddleware(accessLogger, config.Format)(mux)
+	logMiddleware, err := logging.ApacheLogMiddleware(accessLogger, config)
+	if err != nil {
+		serverLogger.Fatal("Failed to build access log middleware",
+			zap.Error(err),
+			zap.String("format", string(config.Format)))
+	}
+	handler := logMiddleware(mux)
 
 	// Configure the HTTP server
 	server := &http.Server{
@@ -108,8 +116,10 @@ func main() {
 	}
 
 	// One final check to secure log files before exiting
-	if err := logging.SecureRotatedLogs(config.LogPath); err != nil {
-		serverLogger.Error("Failed to secure log files during shutdown", zap.Error(err))
+	for _, path := range config.FilePaths() {
+		if err := logging.SecureRotatedLogs(path); err != nil {
+			serverLogger.Error("Failed to secure log files during shutdown", zap.Error(err), zap.String("logPath", path))
+		}
 	}
 
 	serverLogger.Info("Server exited successfully")
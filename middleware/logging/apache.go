@@ -2,9 +2,13 @@ package logging
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -12,39 +16,161 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// Recognized output schemes for ApacheLogConfig.Outputs. Anything else is
+// handed to zap's own sink registry, which covers syslog:// and tcp:// (see
+// init below) as well as any scheme a caller registers via zap.RegisterSink.
+const (
+	outputStdout = "stdout"
+	outputStderr = "stderr"
+	fileScheme   = "file:"
+	syslogScheme = "syslog"
+	tcpScheme    = "tcp"
+)
+
+func init() {
+	// syslog and tcp sinks aren't built into zap, so register them here;
+	// file, stdout, and stderr are handled directly in openOutput.
+	if err := zap.RegisterSink(syslogScheme, newSyslogSink); err != nil {
+		panic(fmt.Sprintf("logging: failed to register %s sink: %v", syslogScheme, err))
+	}
+	if err := zap.RegisterSink(tcpScheme, newTCPSink); err != nil {
+		panic(fmt.Sprintf("logging: failed to register %s sink: %v", tcpScheme, err))
+	}
+}
+
+// sinkConn adapts a net.Conn to the zap.Sink interface (WriteSyncer + Closer).
+// Network connections have no meaningful fsync, so Sync is a no-op.
+type sinkConn struct {
+	net.Conn
+}
+
+func (s *sinkConn) Sync() error { return nil }
+
+// newSyslogSink dials a remote syslog collector over UDP, as addressed by a
+// syslog://host:port output entry.
+func newSyslogSink(u *url.URL) (zap.Sink, error) {
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial syslog sink %s: %w", u.Host, err)
+	}
+	return &sinkConn{conn}, nil
+}
+
+// newTCPSink dials a remote log collector over TCP, as addressed by a
+// tcp://host:port output entry.
+func newTCPSink(u *url.URL) (zap.Sink, error) {
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial tcp sink %s: %w", u.Host, err)
+	}
+	return &sinkConn{conn}, nil
+}
+
 // LogFormat defines the logging format type
 type LogFormat string
 
 const (
-	// CommonLogFormat is the standard Apache Common Log Format
-	// %h %l %u %t \"%r\" %>s %b
-	CommonLogFormat LogFormat = "common"
-
-	// CombinedLogFormat is the Apache Combined Log Format (Common + Referer + User-Agent)
-	// %h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-agent}i\"
-	CombinedLogFormat LogFormat = "combined"
+	// CommonLogFormat is the standard Apache Common Log Format, expressed as
+	// a directive string compiled by compileLogFormat.
+	CommonLogFormat LogFormat = `%h %l %u %t "%r" %>s %b`
+
+	// CombinedLogFormat is the Apache Combined Log Format (Common + Referer + User-Agent).
+	CombinedLogFormat LogFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"`
+
+	// JSONLogFormat emits each entry as structured zap fields instead of a
+	// pre-rendered string, so log shippers can ingest it without regex
+	// parsing.
+	JSONLogFormat LogFormat = "json"
+
+	// ECSLogFormat is JSONLogFormat with field names following the Elastic
+	// Common Schema (e.g. http.request.method, url.original), for direct
+	// ingestion into Elasticsearch.
+	ECSLogFormat LogFormat = "ecs"
 )
 
+// isStructuredFormat reports whether format emits structured zap fields
+// rather than a pre-rendered Apache-style string.
+func isStructuredFormat(format LogFormat) bool {
+	return format == JSONLogFormat || format == ECSLogFormat
+}
+
 // ApacheLogConfig holds configuration for Apache-style access logging
 type ApacheLogConfig struct {
-	LogPath    string
-	MaxSize    int // megabytes
-	MaxBackups int // number of backups
-	MaxAge     int // days
+	// Outputs lists the sinks log entries are fanned out to, mirroring
+	// etcd's LogOutputs. Supported entries are "stdout", "stderr",
+	// "file:/path/to/file" (lumberjack-rotated), "syslog://host:port", and
+	// "tcp://host:port". Any other scheme is passed to zap's sink registry,
+	// so callers can add their own via zap.RegisterSink.
+	Outputs    []string
+	MaxSize    int // megabytes, file sinks only
+	MaxBackups int // number of backups, file sinks only
+	MaxAge     int // days, file sinks only
 	Compress   bool
 	Format     LogFormat
+
+	// Redaction masks sensitive headers, query parameters, and path
+	// segments before an entry reaches a sink.
+	Redaction RedactionConfig
+
+	// Sampling downsamples access log entries that don't match any
+	// SamplingRoutes rule. Zero value disables sampling.
+	Sampling SamplingConfig
+
+	// SamplingRoutes applies a SamplingConfig per matching path, e.g. to
+	// aggressively sample noisy endpoints like /healthz. Checked in order;
+	// the first match wins. 5xx responses always bypass sampling. A rule
+	// whose SamplingConfig is the zero value logs every matching entry;
+	// there is no setting that drops a route's entries outright.
+	SamplingRoutes []RouteSamplingRule
+
+	// CaptureRequestBody and CaptureResponseBody enable capturing the first
+	// MaxBodyBytes of the request/response body as extra fields on
+	// JSON/ECS-formatted entries.
+	CaptureRequestBody  bool
+	CaptureResponseBody bool
+
+	// MaxBodyBytes caps how much of a captured body is kept; the rest is
+	// truncated with a trailing "…" marker. Defaults to 4096 if unset.
+	MaxBodyBytes int
+
+	// BodyContentTypes allowlists which Content-Types are captured (e.g.
+	// "application/json", "text/*"), so binary bodies are skipped. Empty
+	// means capture any content type.
+	BodyContentTypes []string
+
+	// RequestIDHeader is read for an incoming request ID, or generated (as a
+	// UUIDv4) and set on it if absent. Defaults to "X-Request-ID".
+	RequestIDHeader string
+
+	// TraceHeaders are parsed for distributed tracing correlation. Only
+	// "traceparent" (W3C Trace Context) is currently used to extract
+	// trace_id/span_id; defaults to {"traceparent", "tracestate"}.
+	TraceHeaders []string
 }
 
 // DefaultApacheLogConfig returns a default configuration
 func DefaultApacheLogConfig() ApacheLogConfig {
 	return ApacheLogConfig{
-		LogPath:    "/var/log/apache2/access.log",
+		Outputs:    []string{fileScheme + "/var/log/apache2/access.log"},
 		MaxSize:    100,
 		MaxBackups: 5,
 		MaxAge:     30,
 		Compress:   true,
 		Format:     CommonLogFormat,
+		Redaction:  DefaultRedactionConfig(),
+	}
+}
+
+// FilePaths returns the filesystem paths of any file: sinks in the config,
+// so callers can periodically secure rotated files with SecureRotatedLogs.
+func (c ApacheLogConfig) FilePaths() []string {
+	var paths []string
+	for _, output := range c.Outputs {
+		if strings.HasPrefix(output, fileScheme) {
+			paths = append(paths, strings.TrimPrefix(output, fileScheme))
+		}
 	}
+	return paths
 }
 
 // responseWrapper is a custom ResponseWriter that captures status code and bytes written
@@ -52,6 +178,12 @@ type responseWrapper struct {
 	http.ResponseWriter
 	status int
 	size   int
+
+	// capture is the body-capture policy, if response body capture is on.
+	capture *bodyCapture
+	// body holds the captured response body once content-type is checked.
+	body        *cappedBuffer
+	bodyChecked bool
 }
 
 // WriteHeader captures the status code
@@ -60,10 +192,24 @@ func (rw *responseWrapper) WriteHeader(status int) {
 	rw.ResponseWriter.WriteHeader(status)
 }
 
-// Write captures the size of the response
+// Write captures the size of the response, and the first MaxBodyBytes of it
+// if response body capture is enabled and the Content-Type is allowed.
 func (rw *responseWrapper) Write(b []byte) (int, error) {
 	size, err := rw.ResponseWriter.Write(b)
 	rw.size += size
+
+	if rw.capture != nil && rw.capture.captureResponse {
+		if !rw.bodyChecked {
+			rw.bodyChecked = true
+			if matchContentType(rw.Header().Get("Content-Type"), rw.capture.contentTypes) {
+				rw.body = newCappedBuffer(rw.capture.maxBytes)
+			}
+		}
+		if rw.body != nil {
+			rw.body.Write(b)
+		}
+	}
+
 	return size, err
 }
 
@@ -133,18 +279,9 @@ func SecureRotatedLogs(logPath string) error {
 
 // NewApacheLogger creates a Zap logger configured for Apache Log Formats
 func NewApacheLogger(config ApacheLogConfig) (*zap.Logger, error) {
-	// Secure the log file before configuring lumberjack
-	if err := secureLogFile(config.LogPath); err != nil {
-		return nil, err
-	}
-
-	// Configure lumberjack for log rotation
-	logWriter := &lumberjack.Logger{
-		Filename:   config.LogPath,
-		MaxSize:    config.MaxSize,
-		MaxBackups: config.MaxBackups,
-		MaxAge:     config.MaxAge,
-		Compress:   config.Compress,
+	outputs := config.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{outputStdout}
 	}
 
 	// Create custom encoder config to avoid timestamps in the log entry
@@ -164,78 +301,204 @@ func NewApacheLogger(config ApacheLogConfig) (*zap.Logger, error) {
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
 
-	// Create custom encoder and core
-	encoder := zapcore.NewConsoleEncoder(encoderConfig)
-	core := zapcore.NewCore(encoder, zapcore.AddSync(logWriter), zapcore.InfoLevel)
+	// JSON/ECS formats emit structured fields, so they get the JSON encoder;
+	// Common/Combined render a pre-built string through the console encoder.
+	var encoder zapcore.Encoder
+	if isStructuredFormat(config.Format) {
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	} else {
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	}
+
+	// Create a core per output, fanned out with NewTee
+	cores := make([]zapcore.Core, 0, len(outputs))
+	for _, output := range outputs {
+		writer, err := openOutput(output, config)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, zapcore.NewCore(encoder, writer, zapcore.InfoLevel))
+	}
+
+	// Create logger with the combined core
+	return zap.New(zapcore.NewTee(cores...)), nil
+}
 
-	// Create logger with custom core
-	return zap.New(core), nil
+// openOutput resolves a single Outputs entry to a WriteSyncer. file:, stdout,
+// and stderr are handled here; everything else (including syslog:// and
+// tcp://, registered in init) goes through zap's own sink registry.
+func openOutput(output string, config ApacheLogConfig) (zapcore.WriteSyncer, error) {
+	switch {
+	case output == outputStdout:
+		return zapcore.AddSync(os.Stdout), nil
+	case output == outputStderr:
+		return zapcore.AddSync(os.Stderr), nil
+	case strings.HasPrefix(output, fileScheme):
+		return newFileSink(strings.TrimPrefix(output, fileScheme), config)
+	default:
+		// zap.Open's closeFunc is discarded: the sink itself (sinkConn) is
+		// also an io.Closer, but nothing in this package ever calls it, so
+		// syslog/tcp connections live for the process's lifetime. That's
+		// fine for the common case of one logger per process; calling
+		// NewApacheLogger repeatedly with a syslog:// or tcp:// output
+		// (e.g. a config-reload path) will leak one connection per call.
+		sink, _, err := zap.Open(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log output %q: %w", output, err)
+		}
+		return sink, nil
+	}
 }
 
-// formatLogEntry formats a log entry according to the specified format
-func formatLogEntry(r *http.Request, wrapper *responseWrapper, start time.Time, format LogFormat) string {
-	// Get the remote address
-	remoteAddr := r.RemoteAddr
+// newFileSink secures and opens a lumberjack-rotated file sink.
+func newFileSink(path string, config ApacheLogConfig) (zapcore.WriteSyncer, error) {
+	if err := secureLogFile(path); err != nil {
+		return nil, err
+	}
 
-	// Format the time in Apache log format: [day/month/year:hour:minute:second zone]
-	timeFormatted := start.Format("[02/Jan/2006:15:04:05 -0700]")
+	logWriter := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    config.MaxSize,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAge,
+		Compress:   config.Compress,
+	}
 
-	// Base log entry in Common Log Format
-	// %h %l %u %t \"%r\" %>s %b
-	logEntry := fmt.Sprintf("%s - - %s \"%s %s %s\" %d %d",
-		remoteAddr,
-		timeFormatted,
-		r.Method,
-		r.RequestURI,
-		r.Proto,
-		wrapper.status,
-		wrapper.size,
-	)
+	return zapcore.AddSync(logWriter), nil
+}
 
-	// If Combined Log Format is requested, add Referer and User-Agent
-	if format == CombinedLogFormat {
-		referer := r.Header.Get("Referer")
-		if referer == "" {
-			referer = "-"
+// structuredLogFields builds the zap fields for a single access log entry.
+// ECS format uses Elastic Common Schema field names; plain JSON format uses
+// the same values under simpler names.
+func structuredLogFields(r *http.Request, wrapper *responseWrapper, start time.Time, format LogFormat, red *redactor, reqBody, respBody *cappedBuffer, requestID string, trace traceContext) []zap.Field {
+	uri := red.requestURI(r.RequestURI)
+	referer := red.header("Referer", r.Header.Get("Referer"))
+	userAgent := red.header("User-Agent", r.Header.Get("User-Agent"))
+	duration := time.Since(start)
+
+	var fields []zap.Field
+	if format == ECSLogFormat {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
 		}
 
-		userAgent := r.Header.Get("User-Agent")
-		if userAgent == "" {
-			userAgent = "-"
+		fields = []zap.Field{
+			zap.String("http.request.method", r.Method),
+			zap.String("url.original", uri),
+			zap.Int("http.response.status_code", wrapper.status),
+			zap.Int("http.response.body.bytes", wrapper.size),
+			zap.String("source.ip", hostOnly(r.RemoteAddr)),
+			zap.String("user_agent.original", userAgent),
+			zap.String("http.request.referrer", referer),
+			zap.String("url.scheme", scheme),
+			zap.String("http.version", strings.TrimPrefix(r.Proto, "HTTP/")),
+			zap.Int64("event.duration", duration.Nanoseconds()),
+			zap.Time("@timestamp", start),
+			zap.String("http.request.id", requestID),
 		}
+		if port, err := strconv.Atoi(portOnly(r.RemoteAddr)); err == nil {
+			fields = append(fields, zap.Int("source.port", port))
+		}
+		if reqBody != nil {
+			fields = append(fields, zap.String("http.request.body.content", red.apply(reqBody.String())))
+		}
+		if respBody != nil {
+			fields = append(fields, zap.String("http.response.body.content", red.apply(respBody.String())))
+		}
+		if trace.traceID != "" {
+			fields = append(fields, zap.String("trace.id", trace.traceID), zap.String("span.id", trace.spanID))
+		}
+		return fields
+	}
+
+	fields = []zap.Field{
+		zap.String("remote_addr", r.RemoteAddr),
+		zap.String("method", r.Method),
+		zap.String("uri", uri),
+		zap.String("proto", r.Proto),
+		zap.Int("status", wrapper.status),
+		zap.Int("bytes", wrapper.size),
+		zap.String("referer", referer),
+		zap.String("user_agent", userAgent),
+		zap.Duration("duration", duration),
+		zap.Time("time", start),
+		zap.String("request_id", requestID),
+	}
+	if reqBody != nil {
+		fields = append(fields, zap.String("request_body", red.apply(reqBody.String())))
+	}
+	if respBody != nil {
+		fields = append(fields, zap.String("response_body", red.apply(respBody.String())))
+	}
+	if trace.traceID != "" {
+		fields = append(fields, zap.String("trace_id", trace.traceID), zap.String("span_id", trace.spanID))
+	}
+	return fields
+}
 
-		// Add Referer and User-Agent to the log entry
-		logEntry = fmt.Sprintf("%s \"%s\" \"%s\"",
-			logEntry,
-			referer,
-			userAgent,
-		)
+// logAccessEntry logs a single request/response pair in the configured
+// format: JSON/ECS emit structured fields directly, everything else renders
+// through the compiled directive tokens into one string field.
+func logAccessEntry(logger *zap.Logger, r *http.Request, wrapper *responseWrapper, start time.Time, format LogFormat, tokens []logToken, red *redactor, reqBody *cappedBuffer, requestID string, trace traceContext) {
+	if isStructuredFormat(format) {
+		logger.Info("", structuredLogFields(r, wrapper, start, format, red, reqBody, wrapper.body, requestID, trace)...)
+		return
 	}
 
-	return logEntry
+	logger.Info(renderLogFormat(tokens, r, wrapper, start))
 }
 
-// ApacheLogMiddleware creates middleware that logs requests in the configured Apache Log Format
-func ApacheLogMiddleware(logger *zap.Logger, format LogFormat) func(http.Handler) http.Handler {
+// ApacheLogMiddleware creates middleware that logs requests according to
+// config.Format and config.Redaction. Format may be one of the presets
+// (CommonLogFormat, CombinedLogFormat, JSONLogFormat, ECSLogFormat) or a
+// custom Apache mod_log_config directive string, which is compiled once
+// here so an unknown directive is reported at construction time rather than
+// per request.
+func ApacheLogMiddleware(logger *zap.Logger, config ApacheLogConfig) (func(http.Handler) http.Handler, error) {
+	red := newRedactor(config.Redaction)
+	decider := newSamplingDecider(config)
+	bodyCap := newBodyCapture(config)
+	tracer := newRequestTracer(config)
+
+	var tokens []logToken
+	if !isStructuredFormat(config.Format) {
+		var err error
+		tokens, err = compileLogFormat(string(config.Format), red)
+		if err != nil {
+			return nil, fmt.Errorf("apache log middleware: %w", err)
+		}
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
-			// Create a response wrapper to capture the status code and bytes written
+			// Read or generate a request ID and parse trace headers, stashing
+			// the ID on the request's context so handlers can correlate
+			r, trace := tracer.apply(w, r)
+
+			// Tee the request body into a capped buffer, if enabled, before
+			// handing it to downstream handlers
+			reqBody := bodyCap.wrapRequestBody(r)
+
+			// Create a response wrapper to capture the status code, bytes
+			// written, and (if enabled) the response body
 			wrapper := &responseWrapper{
 				ResponseWriter: w,
 				status:         200, // Default status is 200
 				size:           0,
+				capture:        bodyCap,
 			}
 
 			// Process the request
 			next.ServeHTTP(wrapper, r)
 
-			// Format the log entry according to the specified format
-			logEntry := formatLogEntry(r, wrapper, start, format)
-
-			// Log using zap
-			logger.Info(logEntry)
+			// Log the entry in the configured format, sampling by route and
+			// bypassing sampling entirely for 5xx responses
+			if decider.shouldLog(r.URL.Path, wrapper.status) {
+				logAccessEntry(logger, r, wrapper, start, config.Format, tokens, red, reqBody, RequestIDFromContext(r.Context()), trace)
+			}
 		})
-	}
+	}, nil
 }
@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxBodyBytes caps how much of a request/response body is captured
+// when CaptureRequestBody/CaptureResponseBody is on but MaxBodyBytes isn't set.
+const defaultMaxBodyBytes = 4096
+
+// truncationMarker is appended to a captured body that exceeded its cap.
+const truncationMarker = "…"
+
+// bodyCapture holds the compiled body-capture policy for a middleware
+// instance, built once from ApacheLogConfig.
+type bodyCapture struct {
+	captureRequest  bool
+	captureResponse bool
+	maxBytes        int
+	contentTypes    []string
+}
+
+// newBodyCapture compiles config's body-capture fields into a bodyCapture.
+func newBodyCapture(config ApacheLogConfig) *bodyCapture {
+	maxBytes := config.MaxBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxBodyBytes
+	}
+
+	return &bodyCapture{
+		captureRequest:  config.CaptureRequestBody,
+		captureResponse: config.CaptureResponseBody,
+		maxBytes:        maxBytes,
+		contentTypes:    config.BodyContentTypes,
+	}
+}
+
+// wrapRequestBody tees r.Body into a capped buffer so the middleware can log
+// the first MaxBodyBytes bytes while leaving r.Body readable for downstream
+// handlers. Returns nil if request body capture is off or the request's
+// content type isn't in the allowlist.
+func (b *bodyCapture) wrapRequestBody(r *http.Request) *cappedBuffer {
+	if !b.captureRequest || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if !matchContentType(r.Header.Get("Content-Type"), b.contentTypes) {
+		return nil
+	}
+
+	captured := newCappedBuffer(b.maxBytes)
+	r.Body = &teeReadCloser{reader: io.TeeReader(r.Body, captured), closer: r.Body}
+	return captured
+}
+
+// teeReadCloser lets a tee'd io.Reader still satisfy io.ReadCloser by
+// delegating Close to the original body.
+type teeReadCloser struct {
+	reader io.Reader
+	closer io.Closer
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) { return t.reader.Read(p) }
+func (t *teeReadCloser) Close() error               { return t.closer.Close() }
+
+// cappedBuffer collects up to max bytes written to it, silently discarding
+// (but still accepting, so a TeeReader never sees a write error) anything
+// beyond the cap and marking itself truncated.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func newCappedBuffer(max int) *cappedBuffer {
+	return &cappedBuffer{max: max}
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if remaining := c.max - c.buf.Len(); remaining > 0 {
+		if remaining >= len(p) {
+			c.buf.Write(p)
+		} else {
+			c.buf.Write(p[:remaining])
+			c.truncated = true
+		}
+	} else if len(p) > 0 {
+		c.truncated = true
+	}
+	return len(p), nil
+}
+
+// String returns the captured content, with a trailing truncation marker if
+// the body exceeded its cap.
+func (c *cappedBuffer) String() string {
+	if c.truncated {
+		return c.buf.String() + truncationMarker
+	}
+	return c.buf.String()
+}
+
+// matchContentType reports whether contentType is in allowlist. An entry
+// ending in "/*" matches any subtype. An empty allowlist matches everything.
+func matchContentType(contentType string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+
+	for _, pattern := range allowlist {
+		if strings.HasSuffix(pattern, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(mediaType, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
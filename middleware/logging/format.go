@@ -0,0 +1,222 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apacheTimeLayout is the default %t layout: [day/month/year:hour:minute:second zone]
+const apacheTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// logToken renders one piece of a compiled log format for a single request.
+type logToken func(r *http.Request, wrapper *responseWrapper, start time.Time) string
+
+// compileLogFormat compiles an Apache mod_log_config style directive string
+// (e.g. `%h %l %u %t "%r" %>s %b`) into a slice of tokens, so the format only
+// needs to be parsed once, at middleware construction time, rather than on
+// every request. Returns an error if the string contains an unknown
+// directive.
+func compileLogFormat(format string, red *redactor) ([]logToken, error) {
+	var tokens []logToken
+	var literal strings.Builder
+
+	flushLiteral := func() {
+		if literal.Len() == 0 {
+			return
+		}
+		s := literal.String()
+		tokens = append(tokens, func(_ *http.Request, _ *responseWrapper, _ time.Time) string { return s })
+		literal.Reset()
+	}
+
+	runes := []rune(format)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' {
+			literal.WriteRune(runes[i])
+			continue
+		}
+
+		i++
+		if i >= len(runes) {
+			return nil, fmt.Errorf("log format: dangling %% at end of format string")
+		}
+
+		// "%>s" marks the status of the final request in a chain; we only
+		// ever log one request, so '>' is accepted and otherwise ignored.
+		if runes[i] == '>' {
+			i++
+			if i >= len(runes) {
+				return nil, fmt.Errorf("log format: dangling %%> at end of format string")
+			}
+		}
+
+		var arg string
+		if runes[i] == '{' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("log format: unterminated %%{...} directive")
+			}
+			arg = string(runes[i+1 : end])
+			i = end + 1
+			if i >= len(runes) {
+				return nil, fmt.Errorf("log format: missing directive letter after %%{%s}", arg)
+			}
+		}
+
+		token, err := logTokenFor(runes[i], arg, red)
+		if err != nil {
+			return nil, err
+		}
+		flushLiteral()
+		tokens = append(tokens, token)
+	}
+	flushLiteral()
+
+	return tokens, nil
+}
+
+// renderLogFormat runs a compiled format against one request/response pair.
+func renderLogFormat(tokens []logToken, r *http.Request, wrapper *responseWrapper, start time.Time) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString(token(r, wrapper, start))
+	}
+	return sb.String()
+}
+
+// logTokenFor returns the token function for a single compiled directive.
+func logTokenFor(directive rune, arg string, red *redactor) (logToken, error) {
+	switch directive {
+	case 'h', 'a': // %h / %a: remote host / client IP
+		return func(r *http.Request, _ *responseWrapper, _ time.Time) string {
+			return hostOnly(r.RemoteAddr)
+		}, nil
+	case 'l': // %l: remote logname, never available
+		return tokenDash, nil
+	case 'u': // %u: remote user, from HTTP basic auth if present
+		return func(r *http.Request, _ *responseWrapper, _ time.Time) string {
+			if user, _, ok := r.BasicAuth(); ok && user != "" {
+				return user
+			}
+			return "-"
+		}, nil
+	case 't': // %t / %{layout}t: request time
+		layout := apacheTimeLayout
+		if arg != "" {
+			layout = arg
+		}
+		return func(_ *http.Request, _ *responseWrapper, start time.Time) string {
+			return "[" + start.Format(layout) + "]"
+		}, nil
+	case 'r': // %r: first line of the request
+		return func(r *http.Request, _ *responseWrapper, _ time.Time) string {
+			return fmt.Sprintf("%s %s %s", r.Method, red.requestURI(r.RequestURI), r.Proto)
+		}, nil
+	case 's': // %s / %>s: response status code
+		return func(_ *http.Request, wrapper *responseWrapper, _ time.Time) string {
+			return strconv.Itoa(wrapper.status)
+		}, nil
+	case 'b': // %b: response size, "-" if zero
+		return func(_ *http.Request, wrapper *responseWrapper, _ time.Time) string {
+			if wrapper.size == 0 {
+				return "-"
+			}
+			return strconv.Itoa(wrapper.size)
+		}, nil
+	case 'O': // %O: bytes sent, always numeric
+		return func(_ *http.Request, wrapper *responseWrapper, _ time.Time) string {
+			return strconv.Itoa(wrapper.size)
+		}, nil
+	case 'I': // %I: bytes received
+		return func(r *http.Request, _ *responseWrapper, _ time.Time) string {
+			if r.ContentLength < 0 {
+				return "-"
+			}
+			return strconv.FormatInt(r.ContentLength, 10)
+		}, nil
+	case 'i': // %{Header}i: incoming request header
+		if arg == "" {
+			return nil, fmt.Errorf("log format: %%{header}i requires a header name")
+		}
+		return func(r *http.Request, _ *responseWrapper, _ time.Time) string {
+			return headerOrDash(r.Header, arg, red)
+		}, nil
+	case 'o': // %{Header}o: outgoing response header
+		if arg == "" {
+			return nil, fmt.Errorf("log format: %%{header}o requires a header name")
+		}
+		return func(_ *http.Request, wrapper *responseWrapper, _ time.Time) string {
+			return headerOrDash(wrapper.Header(), arg, red)
+		}, nil
+	case 'D': // %D: request duration in microseconds
+		return func(_ *http.Request, _ *responseWrapper, start time.Time) string {
+			return strconv.FormatInt(time.Since(start).Microseconds(), 10)
+		}, nil
+	case 'T': // %T: request duration in whole seconds
+		return func(_ *http.Request, _ *responseWrapper, start time.Time) string {
+			return strconv.FormatInt(int64(time.Since(start).Seconds()), 10)
+		}, nil
+	case 'v', 'V': // %v / %V: canonical server name
+		return func(r *http.Request, _ *responseWrapper, _ time.Time) string {
+			return hostOnly(r.Host)
+		}, nil
+	case 'p': // %p: canonical server port
+		return func(r *http.Request, _ *responseWrapper, _ time.Time) string {
+			return portOnly(r.Host)
+		}, nil
+	case 'A': // %A: local (server) IP address
+		return func(r *http.Request, _ *responseWrapper, _ time.Time) string {
+			addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+			if !ok {
+				return "-"
+			}
+			return hostOnly(addr.String())
+		}, nil
+	case 'X': // %X: connection status, not tracked
+		return tokenDash, nil
+	default:
+		return nil, fmt.Errorf("log format: unknown directive %%%c", directive)
+	}
+}
+
+// tokenDash always renders "-", for directives we have no data for.
+func tokenDash(_ *http.Request, _ *responseWrapper, _ time.Time) string {
+	return "-"
+}
+
+// hostOnly strips the port from a host:port pair, returning the input
+// unchanged if it isn't one.
+func hostOnly(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	return host
+}
+
+// portOnly extracts the port from a host:port pair, returning "-" if it
+// isn't one.
+func portOnly(hostport string) string {
+	_, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "-"
+	}
+	return port
+}
+
+// headerOrDash returns a redacted header value, or "-" if it is unset,
+// matching Apache's convention for empty log fields.
+func headerOrDash(h http.Header, name string, red *redactor) string {
+	v := h.Get(name)
+	if v == "" {
+		return "-"
+	}
+	return red.header(name, v)
+}
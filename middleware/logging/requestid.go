@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultRequestIDHeader is used when ApacheLogConfig.RequestIDHeader is unset.
+const defaultRequestIDHeader = "X-Request-ID"
+
+// defaultTraceHeaders are parsed for W3C Trace Context correlation when
+// ApacheLogConfig.TraceHeaders is unset.
+var defaultTraceHeaders = []string{"traceparent", "tracestate"}
+
+type contextKey string
+
+const requestIDContextKey contextKey = "logging.requestID"
+
+// RequestIDFromContext returns the request ID ApacheLogMiddleware stashed on
+// ctx, or "" if none is present (e.g. the context isn't derived from a
+// request that passed through the middleware).
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// traceContext holds the trace and span IDs parsed from a W3C traceparent header.
+type traceContext struct {
+	traceID string
+	spanID  string
+}
+
+// parseTraceParent parses a W3C Trace Context traceparent header
+// ("version-traceid-spanid-flags") into its trace and span IDs. Returns the
+// zero value if the header doesn't match the expected shape.
+func parseTraceParent(header string) traceContext {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceContext{}
+	}
+	return traceContext{traceID: parts[1], spanID: parts[2]}
+}
+
+// newRequestID generates a random UUIDv4 string.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand failing is effectively unrecoverable; fall back to the
+		// nil UUID rather than letting one bad request take down the server.
+		return "00000000-0000-0000-0000-000000000000"
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10xx
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// requestTracer reads or generates a request ID and parses trace headers for
+// every request, compiled once from ApacheLogConfig.
+type requestTracer struct {
+	requestIDHeader string
+	traceHeaders    []string
+}
+
+// newRequestTracer compiles config's RequestIDHeader and TraceHeaders.
+func newRequestTracer(config ApacheLogConfig) *requestTracer {
+	header := config.RequestIDHeader
+	if header == "" {
+		header = defaultRequestIDHeader
+	}
+
+	headers := config.TraceHeaders
+	if len(headers) == 0 {
+		headers = defaultTraceHeaders
+	}
+
+	return &requestTracer{requestIDHeader: header, traceHeaders: headers}
+}
+
+// apply reads the request ID from the incoming request, generating one if
+// absent, and stashes it on the request's context and response headers so
+// handlers can correlate their own logging with the access log entry. It
+// also sets the ID on r.Header so a %{header}i format directive picks it up
+// like any other header. The returned request must replace r in the caller.
+func (t *requestTracer) apply(w http.ResponseWriter, r *http.Request) (*http.Request, traceContext) {
+	id := r.Header.Get(t.requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		r.Header.Set(t.requestIDHeader, id)
+	}
+	w.Header().Set(t.requestIDHeader, id)
+
+	r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id))
+
+	var trace traceContext
+	for _, name := range t.traceHeaders {
+		if strings.EqualFold(name, "traceparent") {
+			if v := r.Header.Get(name); v != "" {
+				trace = parseTraceParent(v)
+			}
+		}
+	}
+
+	return r, trace
+}
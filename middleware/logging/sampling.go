@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// SamplingConfig controls how aggressively access log entries are
+// downsampled. The zero value disables sampling (every entry is logged).
+//
+// This deliberately doesn't use zapcore.NewSamplerWithOptions: that sampler
+// dedupes by log *message*, which is constant ("") for JSON/ECS but unique
+// per request for CommonLogFormat/CombinedLogFormat (the rendered line is
+// the message), so it would never actually sample those formats. Sampling
+// decisions are made here instead, before an entry is ever handed to zap.
+type SamplingConfig struct {
+	// Initial is how many entries per Tick are logged before Thereafter
+	// kicks in.
+	Initial int
+
+	// Thereafter logs every Nth entry per Tick once Initial has been
+	// reached.
+	Thereafter int
+
+	// Tick is the sampling window. Defaults to one second if unset.
+	Tick time.Duration
+}
+
+// RouteSamplingRule applies a SamplingConfig to requests whose path matches
+// Pattern. Rules are checked in order; the first match wins.
+type RouteSamplingRule struct {
+	Pattern  *regexp.Regexp
+	Sampling SamplingConfig
+}
+
+// samplingDecider decides whether a request's access log entry should be
+// logged at all, based on its path and final status. It's built once at
+// middleware construction time so each route's counter is only set up once.
+type samplingDecider struct {
+	rules          []routeSampleCounter
+	defaultCounter *sampleCounter
+}
+
+type routeSampleCounter struct {
+	pattern *regexp.Regexp
+	counter *sampleCounter
+}
+
+// newSamplingDecider builds a samplingDecider from config's Sampling and
+// SamplingRoutes settings.
+func newSamplingDecider(config ApacheLogConfig) *samplingDecider {
+	rules := make([]routeSampleCounter, 0, len(config.SamplingRoutes))
+	for _, rule := range config.SamplingRoutes {
+		rules = append(rules, routeSampleCounter{
+			pattern: rule.Pattern,
+			counter: newSampleCounter(rule.Sampling),
+		})
+	}
+
+	return &samplingDecider{
+		rules:          rules,
+		defaultCounter: newSampleCounter(config.Sampling),
+	}
+}
+
+// shouldLog reports whether a request's access log entry should be logged.
+// Responses with a 5xx status always bypass sampling so errors are never
+// dropped; otherwise the first matching route rule's counter decides,
+// falling back to the default sampling counter.
+func (d *samplingDecider) shouldLog(path string, status int) bool {
+	if status >= 500 {
+		return true
+	}
+
+	for _, rule := range d.rules {
+		if rule.pattern.MatchString(path) {
+			return rule.counter.allow()
+		}
+	}
+
+	return d.defaultCounter.allow()
+}
+
+// sampleCounter implements the same Initial/Thereafter/Tick semantics as
+// zapcore's sampler, but keyed purely on call count within the current tick
+// rather than on a log message.
+type sampleCounter struct {
+	initial    int
+	thereafter int
+	tick       time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+}
+
+// newSampleCounter builds a sampleCounter from a SamplingConfig. A zero
+// value disables sampling: allow always returns true.
+func newSampleCounter(sampling SamplingConfig) *sampleCounter {
+	tick := sampling.Tick
+	if tick <= 0 {
+		tick = time.Second
+	}
+
+	return &sampleCounter{
+		initial:    sampling.Initial,
+		thereafter: sampling.Thereafter,
+		tick:       tick,
+	}
+}
+
+// allow reports whether the current call should be logged: the first
+// Initial calls per Tick are always allowed, then every Thereafter-th call
+// after that. If both Initial and Thereafter are zero, sampling is disabled
+// and allow always returns true.
+func (s *sampleCounter) allow() bool {
+	if s.initial <= 0 && s.thereafter <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.windowStart.IsZero() || now.Sub(s.windowStart) >= s.tick {
+		s.windowStart = now
+		s.count = 0
+	}
+	s.count++
+
+	if s.count <= s.initial {
+		return true
+	}
+	if s.thereafter <= 0 {
+		return false
+	}
+	return (s.count-s.initial)%s.thereafter == 0
+}